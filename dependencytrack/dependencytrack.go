@@ -5,14 +5,32 @@ import (
 	"encoding/base64"
 	"fmt"
 	"log"
+	"net/http"
+	"strings"
 	"time"
 
 	dtrack "github.com/DependencyTrack/client-go"
+	"github.com/takumakume/sbomreport-to-dependencytrack/pkg/metrics"
+	"github.com/takumakume/sbomreport-to-dependencytrack/pkg/spool"
+	"github.com/takumakume/sbomreport-to-dependencytrack/pkg/xfer"
 )
 
 type DependencyTrackClient interface {
 	UploadBOM(ctx context.Context, projectName, projectVersion string, parentName string, parentVersion string, bom []byte, createTimestamp string) error
-	AddTagsToProject(ctx context.Context, projectName, projectVersion string, tags []string) error
+	AddTagsToProject(ctx context.Context, projectName, projectVersion string, tags []string, reconcileMode string, managedByPrefix string) error
+}
+
+// DefaultManagedByTagPrefix prefixes the tags AddTagsToProject uses to
+// track which tags it owns when running in "sync" reconcile mode.
+const DefaultManagedByTagPrefix = "managed-by:sbomreport-to-dependencytrack/"
+
+// projectService is the subset of dtrack.Client.Project used by
+// AddTagsToProject and IsLatest, extracted as an interface so tests can
+// substitute a fake instead of talking to a real Dependency-Track instance.
+type projectService interface {
+	Lookup(ctx context.Context, name, version string) (dtrack.Project, error)
+	Latest(ctx context.Context, name string) (dtrack.Project, error)
+	Update(ctx context.Context, project dtrack.Project) (dtrack.Project, error)
 }
 
 type DependencyTrack struct {
@@ -20,27 +38,127 @@ type DependencyTrack struct {
 
 	SBOMUploadTimeout       time.Duration
 	SBOMUploadCheckInterval time.Duration
+
+	project         projectService
+	transferManager *xfer.Manager
+	spool           *spool.Spool
 }
 
-func New(baseURL, apiKey string, dtrackClientTimeout, sbomUploadTimeout, sbomUploadCheckInterval time.Duration) (*DependencyTrack, error) {
-	client, err := dtrack.NewClient(baseURL, dtrack.WithAPIKey(apiKey), dtrack.WithTimeout(dtrackClientTimeout))
+// SpoolConfig configures the optional durable retry spool. An empty Dir
+// disables the spool: failed uploads are returned to the caller as errors,
+// as before.
+type SpoolConfig struct {
+	Dir            string
+	MaxFiles       int
+	MaxSizeMB      int
+	ReplayInterval time.Duration
+}
+
+func New(baseURL, apiKey string, dtrackClientTimeout, sbomUploadTimeout, sbomUploadCheckInterval time.Duration, maxConcurrentUploads, maxUploadAttempts int, spoolConfig SpoolConfig) (*DependencyTrack, error) {
+	client, err := dtrack.NewClient(baseURL, dtrack.WithAPIKey(apiKey), dtrack.WithTimeout(dtrackClientTimeout), dtrack.WithHTTPClient(&http.Client{
+		Timeout:   dtrackClientTimeout,
+		Transport: metrics.InstrumentRoundTripper(http.DefaultTransport),
+	}))
 	if err != nil {
 		return nil, err
 	}
 
-	return &DependencyTrack{
+	dt := &DependencyTrack{
 		Client:                  client,
 		SBOMUploadTimeout:       sbomUploadTimeout,
 		SBOMUploadCheckInterval: sbomUploadCheckInterval,
-	}, nil
+		project:                 client.Project,
+	}
+
+	if spoolConfig.Dir != "" {
+		sp, err := spool.New(spoolConfig.Dir, spoolConfig.MaxFiles, spoolConfig.MaxSizeMB)
+		if err != nil {
+			return nil, err
+		}
+		dt.spool = sp
+	}
+
+	dt.transferManager = xfer.New(dt.uploadBOM, maxConcurrentUploads, xfer.RetryConfig{
+		MaxAttempts: maxUploadAttempts,
+	}, dt.spoolExhaustedTransfer)
+
+	if dt.spool != nil {
+		// Replay through the transfer manager rather than calling uploadBOM
+		// directly, so a replay of a spooled job is deduplicated against an
+		// identical upload already in flight instead of racing it.
+		sp := dt.spool
+		sp.StartReplayer(context.Background(), spoolConfig.ReplayInterval, func(ctx context.Context, job xfer.Job) error {
+			return dt.transferManager.Submit(ctx, job)
+		})
+	}
+
+	return dt, nil
 }
 
+// UploadBOM submits bom as a transfer job and waits for it to complete.
+// Concurrent uploads for the same project+BOM are deduplicated onto a
+// single transfer by the transfer manager, which also owns retries and
+// worker concurrency. If the transfer exhausts its retries and a spool is
+// configured, spoolExhaustedTransfer recovers it and this returns nil; the
+// caller otherwise sees the transfer's error, including ctx.Err() if its own
+// wait was cut short while the transfer continues for other subscribers.
 func (dt *DependencyTrack) UploadBOM(ctx context.Context, projectName, projectVersion string, parentName string, parentVersion string, bom []byte, createTimestamp string) error {
+	job := xfer.Job{
+		ProjectName:     projectName,
+		ProjectVersion:  projectVersion,
+		ParentName:      parentName,
+		ParentVersion:   parentVersion,
+		BOM:             bom,
+		CreateTimestamp: createTimestamp,
+	}
+
+	return dt.transferManager.Submit(ctx, job)
+}
+
+// spoolExhaustedTransfer is the transfer manager's OnExhaustedFunc: it is
+// called at most once per transfer that has run out of retries, regardless
+// of how many Submit callers were waiting on it, so a shared failure is
+// spooled once rather than once per subscriber.
+func (dt *DependencyTrack) spoolExhaustedTransfer(job xfer.Job, err error) bool {
+	if dt.spool == nil {
+		return false
+	}
+
+	log.Printf("BOM upload exhausted retries, spooling for later replay: project %s:%s: %s", job.ProjectName, job.ProjectVersion, err)
+	if spoolErr := dt.spool.Enqueue(job, 0); spoolErr != nil {
+		log.Printf("Upload failed (%s) and could not be spooled: %s", err, spoolErr)
+		return false
+	}
+
+	return true
+}
+
+// uploadBOM is the xfer.UploadFunc driving a single attempt: it performs the
+// dtrack.BOM.Upload call and polls IsBeingProcessed until the upload is
+// either accepted or times out. This is the logic that used to live inline
+// in UploadBOM before transfers were routed through the transfer manager.
+//
+// This only times the attempt; it does not touch metrics.UploadsTotal. That
+// counter tracks logical upload results as observed by uploader.Upload.Run,
+// and runWithRetry can call uploadBOM more than once (and multiple
+// dedup'd Submit callers share one underlying call), so counting here would
+// double-count against it.
+func (dt *DependencyTrack) uploadBOM(ctx context.Context, job xfer.Job) error {
+	return metrics.ObserveUploadDuration(func() error {
+		return dt.doUploadBOM(ctx, job)
+	})
+}
+
+func (dt *DependencyTrack) doUploadBOM(ctx context.Context, job xfer.Job) error {
+	projectName, projectVersion := job.ProjectName, job.ProjectVersion
+
 	log.Printf("Uploading BOM: project %s:%s", projectName, projectVersion)
 
-	ts := time.Now().Format(time.RFC3339)
+	ts := job.CreateTimestamp
+	if ts == "" {
+		ts = time.Now().Format(time.RFC3339)
+	}
 
-	// latest := true
 	latest, err := dt.IsLatest(ctx, projectName, projectVersion, ts)
 	if err != nil {
 		return err
@@ -49,10 +167,10 @@ func (dt *DependencyTrack) UploadBOM(ctx context.Context, projectName, projectVe
 	uploadToken, err := dt.Client.BOM.Upload(ctx, dtrack.BOMUploadRequest{
 		ProjectName:    projectName,
 		ProjectVersion: projectVersion,
-		ParentName:     parentName,
-		ParentVersion:  parentVersion,
+		ParentName:     job.ParentName,
+		ParentVersion:  job.ParentVersion,
 		AutoCreate:     true,
-		BOM:            base64.StdEncoding.EncodeToString(bom),
+		BOM:            base64.StdEncoding.EncodeToString(job.BOM),
 		IsLatest:       &latest,
 	})
 	log.Printf("Upload BOM: latest: %v", latest)
@@ -62,6 +180,23 @@ func (dt *DependencyTrack) UploadBOM(ctx context.Context, projectName, projectVe
 
 	log.Printf("Polling completion of upload BOM: project %s:%s token %s", projectName, projectVersion, uploadToken)
 
+	err = metrics.ObserveProcessingWait(ctx, func(ctx context.Context) error {
+		return dt.waitForProcessing(ctx, uploadToken)
+	})
+	if err != nil {
+		log.Printf("Error: BOM upload failed: project %s:%s token %s: %s", projectName, projectVersion, uploadToken, err)
+		return err
+	}
+
+	log.Printf("BOM upload completed: project %s:%s token %s", projectName, projectVersion, uploadToken)
+
+	return nil
+}
+
+// waitForProcessing polls IsBeingProcessed until Dependency-Track has
+// finished ingesting uploadToken, the context is done, or
+// dt.SBOMUploadTimeout elapses.
+func (dt *DependencyTrack) waitForProcessing(ctx context.Context, uploadToken string) error {
 	doneChan := make(chan struct{})
 	errChan := make(chan error)
 
@@ -98,30 +233,110 @@ func (dt *DependencyTrack) UploadBOM(ctx context.Context, projectName, projectVe
 
 	select {
 	case <-doneChan:
-		log.Printf("BOM upload completed: project %s:%s token %s", projectName, projectVersion, uploadToken)
-		break
+		return nil
 	case err := <-errChan:
-		log.Printf("Error: BOM upload failed: project %s:%s token %s: %s", projectName, projectVersion, uploadToken, err)
 		return err
 	}
-
-	return nil
 }
 
-func (dt *DependencyTrack) AddTagsToProject(ctx context.Context, projectName, projectVersion string, tags []string) error {
-	log.Printf("Adding tags to project. project %s:%s tags %v", projectName, projectVersion, tags)
+// AddTagsToProject reconciles tags onto project, rather than blindly
+// appending: repeated uploads of the same project neither accumulate
+// duplicate dtrack.Tag entries nor call Project.Update when nothing
+// changed.
+//
+// reconcileMode controls whether tags this tool previously added but which
+// are no longer in the config are removed:
+//   - "" or "add" (the default): only ever add missing tags.
+//   - "sync": additionally remove tags that were added by a prior run of
+//     this tool (tracked via a managedByPrefix-prefixed tag) but are no
+//     longer present in tags.
+//
+// managedByPrefix defaults to DefaultManagedByTagPrefix when empty.
+func (dt *DependencyTrack) AddTagsToProject(ctx context.Context, projectName, projectVersion string, tags []string, reconcileMode string, managedByPrefix string) error {
+	log.Printf("Reconciling tags on project. project %s:%s tags %v mode %q", projectName, projectVersion, tags, reconcileMode)
+
+	switch reconcileMode {
+	case "", "add", "sync":
+	default:
+		return fmt.Errorf("unknown tags reconcile mode %q", reconcileMode)
+	}
+
+	if managedByPrefix == "" {
+		managedByPrefix = DefaultManagedByTagPrefix
+	}
 
-	project, err := dt.Client.Project.Lookup(ctx, projectName, projectVersion)
+	project, err := dt.project.Lookup(ctx, projectName, projectVersion)
 	if err != nil {
 		return err
 	}
 
+	wanted := make(map[string]struct{}, len(tags))
 	for _, tag := range tags {
-		project.Tags = append(project.Tags, dtrack.Tag{Name: tag})
+		wanted[tag] = struct{}{}
 	}
 
-	_, err = dt.Client.Project.Update(ctx, project)
-	if err != nil {
+	existing := make(map[string]struct{}, len(project.Tags))
+	for _, t := range project.Tags {
+		existing[t.Name] = struct{}{}
+	}
+
+	// stale collects the real tag names whose managed-by marker is present
+	// but which are no longer in tags: in "sync" mode both the marker and
+	// the real tag it tracks are dropped below.
+	stale := make(map[string]struct{})
+	if reconcileMode == "sync" {
+		for _, t := range project.Tags {
+			if managed, ok := strings.CutPrefix(t.Name, managedByPrefix); ok {
+				if _, stillWanted := wanted[managed]; !stillWanted {
+					stale[managed] = struct{}{}
+				}
+			}
+		}
+	}
+
+	reconciled := make([]dtrack.Tag, 0, len(project.Tags)+len(tags))
+	changed := false
+
+	for _, t := range project.Tags {
+		name := t.Name
+		if managed, ok := strings.CutPrefix(name, managedByPrefix); ok {
+			name = managed
+		}
+		if _, ok := stale[name]; ok {
+			changed = true
+			continue
+		}
+		reconciled = append(reconciled, t)
+	}
+
+	added := make(map[string]struct{}, len(tags))
+	for _, tag := range tags {
+		if _, ok := added[tag]; ok {
+			continue
+		}
+		added[tag] = struct{}{}
+
+		if _, ok := existing[tag]; !ok {
+			reconciled = append(reconciled, dtrack.Tag{Name: tag})
+			changed = true
+		}
+		if reconcileMode == "sync" {
+			managedTag := managedByPrefix + tag
+			if _, ok := existing[managedTag]; !ok {
+				reconciled = append(reconciled, dtrack.Tag{Name: managedTag})
+				changed = true
+			}
+		}
+	}
+
+	if !changed {
+		log.Printf("Tags already reconciled, skipping update. project %s:%s", projectName, projectVersion)
+		return nil
+	}
+
+	project.Tags = reconciled
+
+	if _, err := dt.project.Update(ctx, project); err != nil {
 		return err
 	}
 
@@ -137,16 +352,18 @@ func (dt *DependencyTrack) IsLatest(ctx context.Context, projectName, projectVer
 	}
 
 	// Fetch the latest project info from Dependency-Track
-	project, err := dt.Client.Project.Latest(ctx, projectName)
+	project, err := dt.project.Latest(ctx, projectName)
 	if err != nil {
 		log.Printf("No existing project found, treating as latest: %v", err)
 		// If no latest exists, treat this version as latest
+		metrics.IsLatestChecksTotal.WithLabelValues("no_project").Inc()
 		return true, nil
 	}
 
 	// If the version matches the latest, itâ€™s obviously latest
 	if project.Version == projectVersion {
 		log.Printf("Project version: %v matches the latest version in Dependency-Track.", projectVersion)
+		metrics.IsLatestChecksTotal.WithLabelValues("latest").Inc()
 		return true, nil
 	}
 
@@ -157,9 +374,11 @@ func (dt *DependencyTrack) IsLatest(ctx context.Context, projectName, projectVer
 	// If the incoming BOM is newer than the stored latest, mark it as latest
 	if ts.After(latestBOMTime) {
 		log.Printf("Incoming BOM creationTimestamp %v is newer than the latest in Dependency-Track %v.", ts, latestBOMTime)
+		metrics.IsLatestChecksTotal.WithLabelValues("latest").Inc()
 		return true, nil
 	}
 
 	log.Printf("Did not hit any conditions, treating as not latest.")
+	metrics.IsLatestChecksTotal.WithLabelValues("not_latest").Inc()
 	return false, nil
 }
@@ -0,0 +1,115 @@
+package dependencytrack
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	dtrack "github.com/DependencyTrack/client-go"
+)
+
+// fakeProjectService is a minimal in-memory stand-in for dtrack.Client's
+// Project service, letting AddTagsToProject be exercised without a real
+// Dependency-Track instance.
+type fakeProjectService struct {
+	project dtrack.Project
+
+	updated     bool
+	updatedTags []dtrack.Tag
+}
+
+func (f *fakeProjectService) Lookup(ctx context.Context, name, version string) (dtrack.Project, error) {
+	return f.project, nil
+}
+
+func (f *fakeProjectService) Latest(ctx context.Context, name string) (dtrack.Project, error) {
+	return f.project, nil
+}
+
+func (f *fakeProjectService) Update(ctx context.Context, project dtrack.Project) (dtrack.Project, error) {
+	f.updated = true
+	f.updatedTags = project.Tags
+	return project, nil
+}
+
+func tagNames(tags []dtrack.Tag) []string {
+	names := make([]string, 0, len(tags))
+	for _, t := range tags {
+		names = append(names, t.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestAddTagsToProjectAddOnlyAddsMissingTags(t *testing.T) {
+	fake := &fakeProjectService{project: dtrack.Project{Tags: []dtrack.Tag{{Name: "env:prod"}}}}
+	dt := &DependencyTrack{project: fake}
+
+	if err := dt.AddTagsToProject(context.Background(), "app", "1.0.0", []string{"env:prod", "team:payments"}, "add", ""); err != nil {
+		t.Fatalf("AddTagsToProject: %v", err)
+	}
+
+	if !fake.updated {
+		t.Fatal("expected Project.Update to be called")
+	}
+	if got, want := tagNames(fake.updatedTags), []string{"env:prod", "team:payments"}; !equal(got, want) {
+		t.Fatalf("tags = %v, want %v", got, want)
+	}
+}
+
+func TestAddTagsToProjectNoOpWhenAlreadyReconciled(t *testing.T) {
+	fake := &fakeProjectService{project: dtrack.Project{Tags: []dtrack.Tag{
+		{Name: "env:prod"},
+		{Name: "team:payments"},
+	}}}
+	dt := &DependencyTrack{project: fake}
+
+	if err := dt.AddTagsToProject(context.Background(), "app", "1.0.0", []string{"env:prod", "team:payments"}, "add", ""); err != nil {
+		t.Fatalf("AddTagsToProject: %v", err)
+	}
+
+	if fake.updated {
+		t.Fatal("expected Project.Update not to be called when nothing changed")
+	}
+}
+
+func TestAddTagsToProjectSyncRemovesStaleManagedTags(t *testing.T) {
+	const prefix = "managed-by:test/"
+	fake := &fakeProjectService{project: dtrack.Project{Tags: []dtrack.Tag{
+		{Name: "env:prod"},
+		{Name: "team:payments"},
+		{Name: prefix + "team:payments"},
+	}}}
+	dt := &DependencyTrack{project: fake}
+
+	if err := dt.AddTagsToProject(context.Background(), "app", "1.0.0", []string{"env:prod"}, "sync", prefix); err != nil {
+		t.Fatalf("AddTagsToProject: %v", err)
+	}
+
+	if !fake.updated {
+		t.Fatal("expected Project.Update to be called")
+	}
+	if got, want := tagNames(fake.updatedTags), []string{"env:prod", prefix + "env:prod"}; !equal(got, want) {
+		t.Fatalf("tags = %v, want %v (team:payments and its marker should be gone)", got, want)
+	}
+}
+
+func TestAddTagsToProjectRejectsUnknownReconcileMode(t *testing.T) {
+	dt := &DependencyTrack{project: &fakeProjectService{}}
+
+	if err := dt.AddTagsToProject(context.Background(), "app", "1.0.0", nil, "bogus", ""); err == nil {
+		t.Fatal("expected an error for an unknown reconcile mode")
+	}
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,185 @@
+// Package metrics exposes Prometheus instrumentation for the SBOM upload
+// pipeline: counters and histograms around the uploader and
+// Dependency-Track client, plus an HTTP server for /metrics and /healthz.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// UploadsTotal counts every call to uploader.Upload.Run, labelled by
+	// outcome; retried attempts within one call are not counted separately.
+	// reason is empty on success.
+	UploadsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sbomreport_uploads_total",
+		Help: "Total number of SBOM report uploads, by result.",
+	}, []string{"result", "reason"})
+
+	// UploadDuration measures each single upload attempt made by the
+	// transfer manager, including the processing poll loop. Time spent
+	// queued or in backoff between retries is not included.
+	UploadDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "sbomreport_upload_duration_seconds",
+		Help:    "Duration of BOM uploads to Dependency-Track.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	// BOMProcessingWait measures the IsBeingProcessed polling loop alone.
+	BOMProcessingWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "sbomreport_bom_processing_wait_seconds",
+		Help:    "Time spent waiting for Dependency-Track to finish processing an uploaded BOM.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	// IsLatestChecksTotal counts the outcome of DependencyTrack.IsLatest.
+	IsLatestChecksTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sbomreport_is_latest_checks_total",
+		Help: "Total number of is-latest checks, by outcome.",
+	}, []string{"outcome"})
+
+	// DTrackAPIRequestsTotal counts every HTTP request made to
+	// Dependency-Track via the instrumented client transport.
+	DTrackAPIRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sbomreport_dtrack_api_requests_total",
+		Help: "Total number of HTTP requests made to the Dependency-Track API.",
+	}, []string{"endpoint", "status"})
+
+	// SpoolFiles reports the current number of jobs sitting in the durable
+	// retry spool.
+	SpoolFiles = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sbomreport_spool_files",
+		Help: "Number of BOM upload jobs currently held in the durable retry spool.",
+	})
+
+	// SpoolBytes reports the current on-disk size of the durable retry
+	// spool.
+	SpoolBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "sbomreport_spool_bytes",
+		Help: "Total size in bytes of the durable retry spool.",
+	})
+
+	// SpoolReplayTotal counts spool replay attempts, by result.
+	SpoolReplayTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "sbomreport_spool_replay_total",
+		Help: "Total number of durable spool replay attempts, by result.",
+	}, []string{"result"})
+)
+
+// roundTripper decorates an http.RoundTripper with DTrackAPIRequestsTotal
+// accounting, so every request made by the dtrack client is counted
+// regardless of which client-go method issued it.
+type roundTripper struct {
+	next http.RoundTripper
+}
+
+// InstrumentRoundTripper wraps next so that every request it serves is
+// counted in DTrackAPIRequestsTotal, labelled by request path and response
+// status. Install it on the dtrack.Client's transport.
+func InstrumentRoundTripper(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &roundTripper{next: next}
+}
+
+func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := rt.next.RoundTrip(req)
+	status := "error"
+	if err == nil {
+		status = resp.Status
+	}
+	DTrackAPIRequestsTotal.WithLabelValues(templatePath(req.URL.Path), status).Inc()
+	return resp, err
+}
+
+// uuidSegment matches a path segment that is a UUID, e.g. the upload token
+// in /api/v1/bom/token/{uuid}.
+var uuidSegment = regexp.MustCompile(`(?i)^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// templatePath replaces variable path segments (currently just UUIDs, such
+// as the per-upload token polled by waitForProcessing) with a placeholder,
+// so DTrackAPIRequestsTotal doesn't grow an unbounded number of label
+// values over the lifetime of a long-running serve process.
+func templatePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if uuidSegment.MatchString(s) {
+			segments[i] = ":uuid"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// Serve starts an HTTP server on addr exposing /metrics (via
+// promhttp.Handler) and /healthz. It does not block; call Shutdown on the
+// returned server to stop it gracefully. A failure to bind addr (e.g. it's
+// already in use) is logged, not fatal: it takes down the metrics endpoint,
+// not the long-running process serving it.
+func Serve(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok"))
+	})
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("metrics: server on %s stopped unexpectedly: %s", addr, err)
+		}
+	}()
+
+	return srv
+}
+
+// timer is a small helper for observing a histogram over a function call.
+func timer(h prometheus.Histogram) func() {
+	start := time.Now()
+	return func() {
+		h.Observe(time.Since(start).Seconds())
+	}
+}
+
+// ObserveUploadDuration times fn and records it against UploadDuration.
+func ObserveUploadDuration(fn func() error) error {
+	stop := timer(UploadDuration)
+	defer stop()
+	return fn()
+}
+
+// ObserveProcessingWait times fn and records it against BOMProcessingWait.
+func ObserveProcessingWait(ctx context.Context, fn func(ctx context.Context) error) error {
+	stop := timer(BOMProcessingWait)
+	defer stop()
+	return fn(ctx)
+}
+
+// ErrorReason buckets err into a small, fixed set of label values suitable
+// for UploadsTotal's reason label. err.Error() is not used directly there:
+// it's unbounded free-form text (dial errors embed addresses, dtrack errors
+// embed response bodies) and would grow the metric's cardinality without
+// bound over the lifetime of a long-running serve process.
+func ErrorReason(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, context.DeadlineExceeded):
+		return "deadline_exceeded"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	default:
+		return "error"
+	}
+}
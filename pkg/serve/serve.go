@@ -0,0 +1,67 @@
+// Package serve runs a long-lived consumer that pulls SBOM report payloads
+// off a source.Source and hands each one to an uploader.Uploader, acking,
+// requeueing or dead-lettering depending on the outcome. This is what the
+// `serve` subcommand runs instead of the one-shot stdin path.
+package serve
+
+import (
+	"context"
+	"log"
+
+	"github.com/takumakume/sbomreport-to-dependencytrack/pkg/source"
+	"github.com/takumakume/sbomreport-to-dependencytrack/sbomreport"
+	"github.com/takumakume/sbomreport-to-dependencytrack/uploader"
+)
+
+// Run consumes messages from src until ctx is done or src's channel closes,
+// handing each payload to up.Run.
+func Run(ctx context.Context, src source.Source, up uploader.Uploader) error {
+	messages, err := src.Messages(ctx)
+	if err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			handle(ctx, up, msg)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func handle(ctx context.Context, up uploader.Uploader, msg source.Message) {
+	err := up.Run(ctx, msg.Payload)
+
+	switch {
+	case err == nil:
+		if ackErr := msg.Ack(); ackErr != nil {
+			log.Printf("serve: ack failed: %s", ackErr)
+		}
+	case isRetryable(err):
+		log.Printf("serve: retryable error, requeueing: %s", err)
+		if nackErr := msg.Nack(true); nackErr != nil {
+			log.Printf("serve: nack(requeue) failed: %s", nackErr)
+		}
+	default:
+		log.Printf("serve: non-retryable error, dead-lettering: %s", err)
+		if nackErr := msg.Nack(false); nackErr != nil {
+			log.Printf("serve: nack(dead-letter) failed: %s", nackErr)
+		}
+	}
+}
+
+// isRetryable reports whether err is worth requeueing for a later attempt.
+// Everything is retryable by default, including context deadlines and
+// Dependency-Track connectivity errors: a serve process is expected to
+// outlive a transient outage. The one exception, dead-lettered, is an error
+// for which sbomreport.IsErrNotSBOMReport holds. Every other error is
+// requeued, even one that the same payload produces on every redelivery
+// (such as a report whose verb is not update).
+func isRetryable(err error) bool {
+	return !sbomreport.IsErrNotSBOMReport(err)
+}
@@ -0,0 +1,97 @@
+// Package amqp implements source.Source over an AMQP 0-9-1 broker (e.g.
+// RabbitMQ), matching the replication pattern added to stdiscosrv.
+package amqp
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/takumakume/sbomreport-to-dependencytrack/pkg/source"
+)
+
+// Config configures a connection to an AMQP broker and the queue to
+// consume from.
+type Config struct {
+	URL       string
+	Queue     string
+	Prefetch  int
+	TLSConfig *tls.Config // nil dials without TLS
+}
+
+// Source consumes SBOM report messages from a single AMQP queue.
+type Source struct {
+	conn *amqp.Connection
+	ch   *amqp.Channel
+	cfg  Config
+}
+
+// New dials cfg.URL and opens a channel against cfg.Queue, ready to be
+// consumed from via Messages.
+func New(cfg Config) (*Source, error) {
+	var (
+		conn *amqp.Connection
+		err  error
+	)
+	if cfg.TLSConfig != nil {
+		conn, err = amqp.DialTLS(cfg.URL, cfg.TLSConfig)
+	} else {
+		conn, err = amqp.Dial(cfg.URL)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("amqp: dial: %w", err)
+	}
+
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("amqp: open channel: %w", err)
+	}
+
+	if cfg.Prefetch > 0 {
+		if err := ch.Qos(cfg.Prefetch, 0, false); err != nil {
+			ch.Close()
+			conn.Close()
+			return nil, fmt.Errorf("amqp: set qos: %w", err)
+		}
+	}
+
+	return &Source{conn: conn, ch: ch, cfg: cfg}, nil
+}
+
+// Messages starts consuming cfg.Queue and translates each AMQP delivery
+// into a source.Message. Messages are not auto-acked: the caller must Ack
+// or Nack each one.
+func (s *Source) Messages(ctx context.Context) (<-chan source.Message, error) {
+	deliveries, err := s.ch.ConsumeWithContext(ctx, s.cfg.Queue, "", false, false, false, false, nil)
+	if err != nil {
+		return nil, fmt.Errorf("amqp: consume: %w", err)
+	}
+
+	out := make(chan source.Message)
+	go func() {
+		defer close(out)
+		for d := range deliveries {
+			d := d
+			out <- source.Message{
+				Payload: d.Body,
+				Ack:     func() error { return d.Ack(false) },
+				Nack:    func(requeue bool) error { return d.Nack(false, requeue) },
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// Close shuts down the channel and connection.
+func (s *Source) Close() error {
+	chErr := s.ch.Close()
+	connErr := s.conn.Close()
+	if chErr != nil {
+		return chErr
+	}
+	return connErr
+}
@@ -0,0 +1,24 @@
+// Package source defines the interface a message-queue ingestion backend
+// implements so the serve consumer can treat AMQP, and later Kafka or NATS,
+// the same way.
+package source
+
+import "context"
+
+// Message is a single payload delivered by a Source, along with the
+// acknowledgement callbacks the consumer uses to report how it was
+// processed.
+type Message struct {
+	Payload []byte
+	Ack     func() error
+	Nack    func(requeue bool) error
+}
+
+// Source delivers SBOM report payloads from an external queue.
+type Source interface {
+	// Messages returns a channel of incoming messages. The channel is
+	// closed when ctx is done or the underlying connection is
+	// irrecoverably lost.
+	Messages(ctx context.Context) (<-chan Message, error)
+	Close() error
+}
@@ -0,0 +1,246 @@
+// Package spool provides a durable on-disk queue for BOM upload jobs that
+// could not be delivered to Dependency-Track after the in-process retry
+// budget was exhausted. Spooled jobs are replayed in the background so a
+// transient outage becomes a recoverable delay instead of data loss.
+package spool
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/takumakume/sbomreport-to-dependencytrack/pkg/metrics"
+	"github.com/takumakume/sbomreport-to-dependencytrack/pkg/xfer"
+)
+
+// UploadFunc delivers a spooled job. It has the same shape as
+// xfer.UploadFunc so a Spool can replay directly through
+// dependencytrack.DependencyTrack's underlying upload path.
+type UploadFunc func(ctx context.Context, job xfer.Job) error
+
+// record is the on-disk representation of a spooled job.
+type record struct {
+	Job      xfer.Job  `json:"job"`
+	Attempt  int       `json:"attempt"`
+	QueuedAt time.Time `json:"queuedAt"`
+}
+
+// Spool is a durable, file-backed queue of BOM upload jobs.
+type Spool struct {
+	dir          string
+	maxFiles     int
+	maxSizeBytes int64
+}
+
+// New creates a Spool rooted at dir, creating it if necessary. maxFiles and
+// maxSizeMB bound the spool's size; 0 means unbounded.
+func New(dir string, maxFiles int, maxSizeMB int) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("spool: create dir: %w", err)
+	}
+
+	return &Spool{
+		dir:          dir,
+		maxFiles:     maxFiles,
+		maxSizeBytes: int64(maxSizeMB) * 1024 * 1024,
+	}, nil
+}
+
+// fileName returns the deterministic file name for job: same project+BOM
+// always spools to the same file, so a repeated failure overwrites the
+// previous attempt rather than piling up duplicates.
+func (s *Spool) fileName(job xfer.Job) string {
+	return filepath.Join(s.dir, job.Key()+".json")
+}
+
+// Enqueue persists job to disk with the given attempt count, evicting the
+// oldest spooled entries first if this would exceed the configured caps.
+func (s *Spool) Enqueue(job xfer.Job, attempt int) error {
+	rec := record{Job: job, Attempt: attempt, QueuedAt: time.Now()}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("spool: marshal job: %w", err)
+	}
+
+	if err := s.enforceCaps(s.fileName(job), int64(len(data))); err != nil {
+		return err
+	}
+
+	tmp := s.fileName(job) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("spool: write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, s.fileName(job)); err != nil {
+		return fmt.Errorf("spool: rename %s: %w", tmp, err)
+	}
+
+	log.Printf("Spooled BOM upload for replay: project %s:%s attempt %d", job.ProjectName, job.ProjectVersion, attempt)
+	s.reportGauges()
+
+	return nil
+}
+
+// enforceCaps evicts the oldest spooled files until adding incoming bytes
+// would stay within maxFiles/maxSizeBytes. replacing is the path Enqueue is
+// about to write: since a job's file name is deterministic per key,
+// re-enqueuing an already-spooled job overwrites its own file rather than
+// adding a new one, so that file is excluded from the count/size this
+// checks against.
+func (s *Spool) enforceCaps(replacing string, incoming int64) error {
+	if s.maxFiles <= 0 && s.maxSizeBytes <= 0 {
+		return nil
+	}
+
+	entries, err := s.listByAge()
+	if err != nil {
+		return err
+	}
+
+	for i, e := range entries {
+		if e.path == replacing {
+			entries = append(entries[:i:i], entries[i+1:]...)
+			break
+		}
+	}
+
+	var total int64
+	for _, e := range entries {
+		total += e.size
+	}
+
+	for (s.maxFiles > 0 && len(entries) >= s.maxFiles) || (s.maxSizeBytes > 0 && total+incoming > s.maxSizeBytes) {
+		if len(entries) == 0 {
+			break
+		}
+		oldest := entries[0]
+		log.Printf("Spool: evicting oldest entry %s to stay within caps", oldest.path)
+		if err := os.Remove(oldest.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("spool: evict %s: %w", oldest.path, err)
+		}
+		total -= oldest.size
+		entries = entries[1:]
+	}
+
+	return nil
+}
+
+type spoolFile struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+func (s *Spool) listByAge() ([]spoolFile, error) {
+	matches, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("spool: list: %w", err)
+	}
+
+	files := make([]spoolFile, 0, len(matches))
+	for _, m := range matches {
+		info, err := os.Stat(m)
+		if err != nil {
+			continue
+		}
+		files = append(files, spoolFile{path: m, size: info.Size(), modTime: info.ModTime()})
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+
+	return files, nil
+}
+
+func (s *Spool) reportGauges() {
+	files, err := s.listByAge()
+	if err != nil {
+		return
+	}
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	metrics.SpoolFiles.Set(float64(len(files)))
+	metrics.SpoolBytes.Set(float64(total))
+}
+
+// Replay attempts to deliver every spooled job through upload, deleting each
+// file on success and leaving it in place (for the next Replay) on failure.
+func (s *Spool) Replay(ctx context.Context, upload UploadFunc) {
+	files, err := s.listByAge()
+	if err != nil {
+		log.Printf("Spool: replay: %s", err)
+		return
+	}
+
+	for _, f := range files {
+		if ctx.Err() != nil {
+			return
+		}
+
+		data, err := os.ReadFile(f.path)
+		if err != nil {
+			log.Printf("Spool: replay: read %s: %s", f.path, err)
+			continue
+		}
+
+		var rec record
+		if err := json.Unmarshal(data, &rec); err != nil {
+			log.Printf("Spool: replay: decode %s: %s, dropping", f.path, err)
+			os.Remove(f.path)
+			continue
+		}
+
+		if err := upload(ctx, rec.Job); err != nil {
+			log.Printf("Spool: replay: %s:%s still failing: %s", rec.Job.ProjectName, rec.Job.ProjectVersion, err)
+			metrics.SpoolReplayTotal.WithLabelValues("error").Inc()
+			continue
+		}
+
+		// upload can return nil after the job failed again and was spooled
+		// anew under the same file name; a file rewritten while upload ran
+		// is kept for the next Replay.
+		if info, statErr := os.Stat(f.path); statErr == nil && !info.ModTime().Equal(f.modTime) {
+			log.Printf("Spool: replay: %s:%s still failing, re-spooled", rec.Job.ProjectName, rec.Job.ProjectVersion)
+			metrics.SpoolReplayTotal.WithLabelValues("error").Inc()
+			continue
+		}
+
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Spool: replay: remove %s: %s", f.path, err)
+		}
+		log.Printf("Spool: replay: delivered spooled upload %s:%s", rec.Job.ProjectName, rec.Job.ProjectVersion)
+		metrics.SpoolReplayTotal.WithLabelValues("success").Inc()
+	}
+
+	s.reportGauges()
+}
+
+// StartReplayer runs Replay once immediately and then on every tick of
+// interval until ctx is done.
+func (s *Spool) StartReplayer(ctx context.Context, interval time.Duration, upload UploadFunc) {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	go func() {
+		s.Replay(ctx, upload)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				s.Replay(ctx, upload)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,108 @@
+package spool
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/takumakume/sbomreport-to-dependencytrack/pkg/xfer"
+)
+
+func job(name string) xfer.Job {
+	return xfer.Job{ProjectName: name, ProjectVersion: "1.0.0", BOM: []byte(name)}
+}
+
+func TestEnqueueEvictsOldestWhenMaxFilesExceeded(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := New(dir, 2, 0)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	for _, name := range []string{"a", "b", "c"} {
+		if err := sp.Enqueue(job(name), 0); err != nil {
+			t.Fatalf("Enqueue %s: %v", name, err)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatalf("glob: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("got %d spooled files, want 2 (maxFiles=2)", len(files))
+	}
+
+	aPath := filepath.Join(dir, job("a").Key()+".json")
+	if _, err := os.Stat(aPath); !os.IsNotExist(err) {
+		t.Fatalf("expected the oldest entry (a) to have been evicted, stat err = %v", err)
+	}
+}
+
+// TestEnqueueReplacingExistingKeyDoesNotCountAsNew guards against
+// re-enqueuing an already-spooled job (same deterministic file name) being
+// treated as a brand new entry by enforceCaps and evicting an unrelated,
+// still-valid spooled job to make room for what is really just an
+// overwrite.
+func TestEnqueueReplacingExistingKeyDoesNotCountAsNew(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := New(dir, 2, 0)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	if err := sp.Enqueue(job("a"), 0); err != nil {
+		t.Fatalf("Enqueue a: %v", err)
+	}
+	if err := sp.Enqueue(job("b"), 0); err != nil {
+		t.Fatalf("Enqueue b: %v", err)
+	}
+
+	if err := sp.Enqueue(job("a"), 1); err != nil {
+		t.Fatalf("Enqueue a (retry): %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatalf("glob: %v", err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("got %d spooled files, want 2", len(files))
+	}
+
+	bPath := filepath.Join(dir, job("b").Key()+".json")
+	if _, err := os.Stat(bPath); err != nil {
+		t.Fatalf("expected b to survive a's re-enqueue, stat err = %v", err)
+	}
+}
+
+func TestEnqueueEvictsOldestWhenMaxSizeExceeded(t *testing.T) {
+	dir := t.TempDir()
+	// Each record is a few hundred bytes once marshalled; a 1-byte cap
+	// means the second enqueue must evict the first.
+	sp, err := New(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	sp.maxSizeBytes = 1
+
+	if err := sp.Enqueue(job("a"), 0); err != nil {
+		t.Fatalf("Enqueue a: %v", err)
+	}
+	if err := sp.Enqueue(job("b"), 0); err != nil {
+		t.Fatalf("Enqueue b: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatalf("glob: %v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("got %d spooled files, want 1 (maxSizeBytes forces eviction down to the newest)", len(files))
+	}
+
+	bPath := filepath.Join(dir, job("b").Key()+".json")
+	if _, err := os.Stat(bPath); err != nil {
+		t.Fatalf("expected b (the newest) to remain, stat err = %v", err)
+	}
+}
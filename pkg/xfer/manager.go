@@ -0,0 +1,262 @@
+// Package xfer provides a small transfer manager for running BOM uploads
+// through a bounded worker pool with deduplication, retries and refcounted
+// cancellation. It is deliberately generic over what an "upload" is so it
+// can sit in front of dependencytrack.DependencyTrack without depending on
+// the dtrack client itself.
+package xfer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Job describes a single BOM upload request. Two jobs with the same Key are
+// treated as the same transfer: the second caller subscribes to the first
+// rather than triggering a second upload.
+type Job struct {
+	ProjectName     string
+	ProjectVersion  string
+	ParentName      string
+	ParentVersion   string
+	BOM             []byte
+	CreateTimestamp string
+}
+
+// Key returns the deduplication key for the job.
+func (j Job) Key() string {
+	sum := sha256.Sum256(j.BOM)
+	return fmt.Sprintf("%s:%s:%s", j.ProjectName, j.ProjectVersion, hex.EncodeToString(sum[:]))
+}
+
+// UploadFunc performs a single attempt at an upload. It is called with a
+// context that is cancelled once every subscriber of the transfer has gone
+// away, and is retried by the Manager on a non-nil error.
+type UploadFunc func(ctx context.Context, job Job) error
+
+// RetryConfig controls the backoff applied between attempts of a failed
+// transfer.
+type RetryConfig struct {
+	MaxAttempts int           // <= 1 disables retries
+	BaseDelay   time.Duration // delay before the first retry
+	MaxDelay    time.Duration // upper bound on backoff delay
+}
+
+func (r RetryConfig) withDefaults() RetryConfig {
+	if r.MaxAttempts <= 0 {
+		r.MaxAttempts = 1
+	}
+	if r.BaseDelay <= 0 {
+		r.BaseDelay = 500 * time.Millisecond
+	}
+	if r.MaxDelay <= 0 {
+		r.MaxDelay = 30 * time.Second
+	}
+	return r
+}
+
+// backoff returns the delay before attempt n+1, as exponential backoff with
+// full jitter.
+func (r RetryConfig) backoff(attempt int) time.Duration {
+	d := r.BaseDelay * time.Duration(1<<uint(attempt-1))
+	if d > r.MaxDelay || d <= 0 {
+		d = r.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// transfer is a single in-flight or queued upload, possibly shared by
+// multiple subscribers.
+type transfer struct {
+	job Job
+	key string
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	done chan struct{}
+	err  error
+
+	mu       sync.Mutex
+	refcount int
+}
+
+func (t *transfer) subscribe() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.refcount++
+}
+
+// unsubscribe drops a subscriber and cancels the transfer once nobody is
+// left waiting on it.
+func (t *transfer) unsubscribe() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.refcount--
+	if t.refcount <= 0 {
+		t.cancel()
+	}
+}
+
+// OnExhaustedFunc is invoked at most once per transfer, after its retry
+// budget has been exhausted (or it was cancelled with no subscribers left),
+// regardless of how many subscribers were attached to it. It reports
+// whether it was able to durably hand off job some other way, in which case
+// the transfer is considered recovered: every subscriber still waiting sees
+// a nil error instead of the original failure.
+type OnExhaustedFunc func(job Job, err error) (recovered bool)
+
+// Manager runs a bounded pool of workers that execute upload jobs,
+// deduplicating concurrent requests for the same project+BOM and retrying
+// transient failures with exponential backoff.
+type Manager struct {
+	upload      UploadFunc
+	retry       RetryConfig
+	onExhausted OnExhaustedFunc
+
+	jobs chan *transfer
+
+	mu       sync.Mutex
+	inflight map[string]*transfer
+
+	wg     sync.WaitGroup
+	stop   chan struct{}
+	stopOk sync.Once
+}
+
+// New starts a Manager backed by workers goroutines. uploadFn is called
+// once per attempt for each distinct transfer. onExhausted may be nil; if
+// set, it is given a last chance to recover a transfer that has run out of
+// retries, exactly once per transfer no matter how many callers are
+// waiting on it.
+func New(uploadFn UploadFunc, workers int, retry RetryConfig, onExhausted OnExhaustedFunc) *Manager {
+	if workers <= 0 {
+		workers = 1
+	}
+
+	m := &Manager{
+		upload:      uploadFn,
+		retry:       retry.withDefaults(),
+		onExhausted: onExhausted,
+		jobs:        make(chan *transfer),
+		inflight:    make(map[string]*transfer),
+		stop:        make(chan struct{}),
+	}
+
+	for i := 0; i < workers; i++ {
+		m.wg.Add(1)
+		go m.worker()
+	}
+
+	return m
+}
+
+// Submit enqueues job for upload, or attaches to an already in-flight
+// transfer for the same key. It blocks until the transfer completes or ctx
+// is done. If ctx is done first, this caller stops waiting but the
+// transfer itself is only cancelled once every subscriber has left.
+func (m *Manager) Submit(ctx context.Context, job Job) error {
+	key := job.Key()
+
+	m.mu.Lock()
+	t, ok := m.inflight[key]
+	if !ok {
+		tctx, cancel := context.WithCancel(context.Background())
+		t = &transfer{job: job, key: key, ctx: tctx, cancel: cancel, done: make(chan struct{}), refcount: 1}
+		m.inflight[key] = t
+		m.mu.Unlock()
+
+		// Handing the transfer to a worker runs on its own goroutine,
+		// independent of this particular caller's ctx: a transfer is only
+		// abandoned via m.stop, never just because the subscriber that
+		// happened to create it stopped waiting.
+		go func() {
+			select {
+			case m.jobs <- t:
+			case <-m.stop:
+				t.err = fmt.Errorf("xfer: manager closed")
+				m.mu.Lock()
+				delete(m.inflight, key)
+				m.mu.Unlock()
+				close(t.done)
+			}
+		}()
+	} else {
+		t.subscribe()
+		m.mu.Unlock()
+	}
+
+	select {
+	case <-t.done:
+	case <-ctx.Done():
+		t.unsubscribe()
+		return ctx.Err()
+	}
+
+	return t.err
+}
+
+func (m *Manager) worker() {
+	defer m.wg.Done()
+	for {
+		select {
+		case t := <-m.jobs:
+			t.err = m.runWithRetry(t)
+			if t.err != nil && m.onExhausted != nil && m.onExhausted(t.job, t.err) {
+				t.err = nil
+			}
+			// Delete before close: once subscribers observe t.done, the
+			// transfer must already be gone from inflight so a new Submit
+			// for the same key starts a fresh transfer instead of
+			// subscribing to this finished (and possibly now-stale) one.
+			m.mu.Lock()
+			delete(m.inflight, t.key)
+			m.mu.Unlock()
+			close(t.done)
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// runWithRetry retries the underlying UploadFunc on error, up to
+// retry.MaxAttempts times, stopping early if the transfer's context is
+// cancelled (every subscriber left).
+func (m *Manager) runWithRetry(t *transfer) error {
+	var err error
+
+	for attempt := 1; attempt <= m.retry.MaxAttempts; attempt++ {
+		err = m.upload(t.ctx, t.job)
+		if err == nil {
+			return nil
+		}
+		if t.ctx.Err() != nil {
+			return t.ctx.Err()
+		}
+		if attempt == m.retry.MaxAttempts {
+			break
+		}
+
+		select {
+		case <-time.After(m.retry.backoff(attempt)):
+		case <-t.ctx.Done():
+			return t.ctx.Err()
+		}
+	}
+
+	return err
+}
+
+// Close stops accepting new work and waits for running workers to exit.
+// In-flight transfers that have already been handed to a worker are left
+// to finish.
+func (m *Manager) Close() {
+	m.stopOk.Do(func() {
+		close(m.stop)
+	})
+	m.wg.Wait()
+}
@@ -0,0 +1,149 @@
+package xfer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testJob() Job {
+	return Job{ProjectName: "app", ProjectVersion: "1.0.0", BOM: []byte("bom")}
+}
+
+func TestSubmitDedupesConcurrentCallsForSameKey(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	upload := func(ctx context.Context, job Job) error {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return nil
+	}
+
+	m := New(upload, 2, RetryConfig{MaxAttempts: 1}, nil)
+	defer m.Close()
+
+	job := testJob()
+
+	const subscribers = 5
+	var wg sync.WaitGroup
+	errs := make([]error, subscribers)
+	for i := 0; i < subscribers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = m.Submit(context.Background(), job)
+		}(i)
+	}
+
+	time.Sleep(50 * time.Millisecond) // let every subscriber attach before the upload completes
+	close(release)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Fatalf("upload called %d times, want 1", got)
+	}
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("subscriber %d: Submit() = %v, want nil", i, err)
+		}
+	}
+}
+
+func TestSubmitCancelWhileInFlightDoesNotAbandonOtherSubscribers(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	upload := func(ctx context.Context, job Job) error {
+		close(started)
+		<-release
+		return nil
+	}
+
+	m := New(upload, 1, RetryConfig{MaxAttempts: 1}, nil)
+	defer m.Close()
+
+	job := testJob()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	abandonedErr := make(chan error, 1)
+	go func() { abandonedErr <- m.Submit(ctx, job) }()
+
+	<-started // the upload is running on behalf of the creating subscriber
+
+	survivorErr := make(chan error, 1)
+	go func() { survivorErr <- m.Submit(context.Background(), job) }()
+	time.Sleep(20 * time.Millisecond) // let the survivor attach to the same transfer
+
+	cancel() // the creating subscriber gives up
+	if err := <-abandonedErr; !errors.Is(err, context.Canceled) {
+		t.Fatalf("abandoned Submit() = %v, want context.Canceled", err)
+	}
+
+	close(release) // the transfer itself keeps running and now completes
+	if err := <-survivorErr; err != nil {
+		t.Fatalf("surviving Submit() = %v, want nil: the transfer must not be abandoned just because one subscriber left", err)
+	}
+}
+
+func TestSubmitRetriesOnFailure(t *testing.T) {
+	var attempts int32
+	upload := func(ctx context.Context, job Job) error {
+		if atomic.AddInt32(&attempts, 1) < 3 {
+			return errors.New("transient")
+		}
+		return nil
+	}
+
+	m := New(upload, 1, RetryConfig{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}, nil)
+	defer m.Close()
+
+	if err := m.Submit(context.Background(), testJob()); err != nil {
+		t.Fatalf("Submit() = %v, want nil after retries", err)
+	}
+	if got := atomic.LoadInt32(&attempts); got != 3 {
+		t.Fatalf("attempts = %d, want 3", got)
+	}
+}
+
+// TestSubmitStartsFreshTransferAfterAllSubscribersAbandon guards against a
+// transfer whose only subscriber left via ctx.Done() being left behind in
+// m.inflight forever: a later Submit for the identical key must trigger a
+// brand new upload rather than attaching to the finished-but-never-cleaned-up
+// transfer and replaying its stale cached result.
+func TestSubmitStartsFreshTransferAfterAllSubscribersAbandon(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	upload := func(ctx context.Context, job Job) error {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return nil
+	}
+
+	m := New(upload, 1, RetryConfig{MaxAttempts: 1}, nil)
+	defer m.Close()
+
+	job := testJob()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan struct{})
+	go func() {
+		m.Submit(ctx, job)
+		close(first)
+	}()
+
+	time.Sleep(20 * time.Millisecond) // let it become the sole subscriber
+	cancel()
+	<-first
+
+	close(release)                    // let the abandoned transfer finish in the background
+	time.Sleep(20 * time.Millisecond) // give the worker time to clean up m.inflight
+
+	if err := m.Submit(context.Background(), job); err != nil {
+		t.Fatalf("Submit() after abandonment = %v, want nil", err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Fatalf("upload called %d times, want 2 (a fresh transfer, not a stale cached result)", got)
+	}
+}
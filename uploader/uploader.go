@@ -5,9 +5,11 @@ import (
 	"errors"
 	"log"
 	"strconv"
+	"time"
 
 	"github.com/takumakume/sbomreport-to-dependencytrack/config"
 	"github.com/takumakume/sbomreport-to-dependencytrack/dependencytrack"
+	"github.com/takumakume/sbomreport-to-dependencytrack/pkg/metrics"
 	"github.com/takumakume/sbomreport-to-dependencytrack/sbomreport"
 	tmpl "github.com/takumakume/sbomreport-to-dependencytrack/template"
 )
@@ -23,7 +25,12 @@ type Upload struct {
 }
 
 func New(c *config.Config) (*Upload, error) {
-	dtrack, err := dependencytrack.New(c.BaseURL, c.APIKey, c.DtrackClientTimeout, c.SBOMUploadTimeout, c.SBOMUploadCheckInterval)
+	dtrack, err := dependencytrack.New(c.BaseURL, c.APIKey, c.DtrackClientTimeout, c.SBOMUploadTimeout, c.SBOMUploadCheckInterval, c.MaxConcurrentUploads, c.MaxUploadAttempts, dependencytrack.SpoolConfig{
+		Dir:            c.SpoolDir,
+		MaxFiles:       c.SpoolMaxFiles,
+		MaxSizeMB:      c.SpoolMaxSizeMB,
+		ReplayInterval: c.SpoolReplayInterval,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -35,11 +42,26 @@ func New(c *config.Config) (*Upload, error) {
 	}, nil
 }
 
-func (u *Upload) Run(ctx context.Context, input []byte) error {
+func (u *Upload) Run(ctx context.Context, input []byte) (err error) {
+	skipped := false
+
+	defer func() {
+		switch {
+		case skipped:
+			// already recorded at the skip site, with its own reason
+		case err == nil:
+			metrics.UploadsTotal.WithLabelValues("success", "").Inc()
+		default:
+			metrics.UploadsTotal.WithLabelValues("error", metrics.ErrorReason(err)).Inc()
+		}
+	}()
+
 	sbom, err := sbomreport.New(input)
 	if err != nil {
 		if sbomreport.IsErrNotSBOMReport(err) {
 			log.Printf("SKIP: %s", err)
+			skipped = true
+			metrics.UploadsTotal.WithLabelValues("skipped", "not_sbom_report").Inc()
 			return nil
 		}
 		return err
@@ -85,18 +107,17 @@ func (u *Upload) Run(ctx context.Context, input []byte) error {
 		return err
 	}
 
-	incomingTS, errTS := strconv.ParseInt(sbom.UpdateTimestamp, 10, 64)
-	isLatest := true
-	if errTS == nil {
-		isLatest = u.dtrack.IsLatest(ctx, projectName, projectVersion, incomingTS)
+	createTimestamp := ""
+	if ts, err := strconv.ParseInt(sbom.UpdateTimestamp, 10, 64); err == nil {
+		createTimestamp = time.Unix(ts, 0).UTC().Format(time.RFC3339)
 	}
 
-	if err := u.dtrack.UploadBOM(ctx, projectName, projectVersion, parentName, parentVersion, sbom.BOM(), isLatest, "", ""); err != nil {
+	if err := u.dtrack.UploadBOM(ctx, projectName, projectVersion, parentName, parentVersion, sbom.BOM(), createTimestamp); err != nil {
 		return err
 	}
 
 	if len(projectTags) > 0 {
-		if err := u.dtrack.AddTagsToProject(ctx, projectName, projectVersion, projectTags, "", ""); err != nil {
+		if err := u.dtrack.AddTagsToProject(ctx, projectName, projectVersion, projectTags, u.config.TagsReconcileMode, u.config.TagsManagedByPrefix); err != nil {
 			return err
 		}
 	}